@@ -0,0 +1,191 @@
+// Copyright (c) 2020 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fanout
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// cacheKey identifies a cached answer: the question plus the upstream that
+// answered it, since two upstreams can legitimately disagree.
+type cacheKey struct {
+	qname  string
+	qtype  uint16
+	qclass uint16
+	to     string
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	msg     *dns.Msg
+	expires time.Time
+}
+
+// responseCache is a per-upstream, size-bounded LRU of recent answers,
+// honoring the minimum TTL of the answer (or the SOA minimum for negative
+// responses) rather than a single fixed TTL. A nil *responseCache is a
+// valid, always-empty cache so callers don't have to nil-check it.
+type responseCache struct {
+	mu         sync.Mutex
+	capacity   int
+	successTTL time.Duration
+	denialTTL  time.Duration
+	ll         *list.List
+	items      map[cacheKey]*list.Element
+}
+
+// newResponseCache returns a cache holding up to capacity answers.
+// successTTL, when non-zero, caps how long a positive answer is kept
+// regardless of its own TTL. denialTTL, when non-zero, overrides the SOA
+// minimum used to cache NXDOMAIN/negative answers.
+func newResponseCache(capacity int, successTTL, denialTTL time.Duration) *responseCache {
+	return &responseCache{
+		capacity:   capacity,
+		successTTL: successTTL,
+		denialTTL:  denialTTL,
+		ll:         list.New(),
+		items:      make(map[cacheKey]*list.Element),
+	}
+}
+
+func keyFor(to string, r *dns.Msg) (cacheKey, bool) {
+	if len(r.Question) == 0 {
+		return cacheKey{}, false
+	}
+	q := r.Question[0]
+	return cacheKey{qname: q.Name, qtype: q.Qtype, qclass: q.Qclass, to: to}, true
+}
+
+// get returns a cached answer for r from upstream to, if one is present and
+// still within its TTL.
+func (rc *responseCache) get(to string, r *dns.Msg) (*dns.Msg, bool) {
+	if rc == nil {
+		return nil, false
+	}
+	key, ok := keyFor(to, r)
+	if !ok {
+		return nil, false
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	el, ok := rc.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		rc.removeLocked(el)
+		return nil, false
+	}
+	rc.ll.MoveToFront(el)
+
+	msg := entry.msg.Copy()
+	msg.Id = r.Id
+	return msg, true
+}
+
+// set stores m as the answer for r from upstream to, if m's TTL makes it
+// worth caching.
+func (rc *responseCache) set(to string, r, m *dns.Msg) {
+	if rc == nil || rc.capacity <= 0 || m == nil {
+		return
+	}
+	key, ok := keyFor(to, r)
+	if !ok {
+		return
+	}
+	ttl := rc.ttlFor(m)
+	if ttl <= 0 {
+		return
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	entry := &cacheEntry{key: key, msg: m.Copy(), expires: time.Now().Add(ttl)}
+	if el, ok := rc.items[key]; ok {
+		el.Value = entry
+		rc.ll.MoveToFront(el)
+		return
+	}
+	rc.items[key] = rc.ll.PushFront(entry)
+	if rc.ll.Len() > rc.capacity {
+		rc.removeLocked(rc.ll.Back())
+	}
+}
+
+// evict drops every entry cached for upstream to. Called when to is ejected
+// by the health-checker so stale answers don't outlive the outage.
+func (rc *responseCache) evict(to string) {
+	if rc == nil {
+		return
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	for key, el := range rc.items {
+		if key.to == to {
+			rc.removeLocked(el)
+		}
+	}
+}
+
+// removeLocked removes el from the cache. rc.mu must already be held.
+func (rc *responseCache) removeLocked(el *list.Element) {
+	rc.ll.Remove(el)
+	delete(rc.items, el.Value.(*cacheEntry).key)
+}
+
+// ttlFor returns how long m should be cached for: the lowest TTL among its
+// answers (capped by successTTL if configured), or, for a negative response
+// (NXDOMAIN or NOERROR without answers), denialTTL if configured and the SOA
+// minimum otherwise. Responses with any other rcode are not cached.
+func (rc *responseCache) ttlFor(m *dns.Msg) time.Duration {
+	if m.Rcode != dns.RcodeSuccess && m.Rcode != dns.RcodeNameError {
+		return 0
+	}
+	if m.Rcode == dns.RcodeSuccess && len(m.Answer) > 0 {
+		var ttl uint32
+		for i, rr := range m.Answer {
+			if i == 0 || rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+			}
+		}
+		d := time.Duration(ttl) * time.Second
+		if rc.successTTL > 0 && d > rc.successTTL {
+			d = rc.successTTL
+		}
+		return d
+	}
+
+	if rc.denialTTL > 0 {
+		return rc.denialTTL
+	}
+	for _, rr := range m.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return time.Duration(soa.Minttl) * time.Second
+		}
+	}
+	return 0
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2020 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fanout
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+)
+
+func TestFanoutCacheAvoidsSecondUpstreamQuery(t *testing.T) {
+	var mutex sync.Mutex
+	hitCount := 0
+	s := newServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		mutex.Lock()
+		hitCount++
+		mutex.Unlock()
+		msg := dns.Msg{
+			Answer: []dns.RR{makeRecordA("example1. 3600	IN	A 10.0.0.1")},
+		}
+		msg.SetReply(r)
+		logErrIfNotNil(w.WriteMsg(&msg))
+	})
+	defer s.close()
+
+	f := New()
+	f.from = "."
+	f.cache = newResponseCache(100, 0, 0)
+	f.addClient(NewClient(s.addr, "tcp"))
+
+	for i := 0; i < 2; i++ {
+		req := new(dns.Msg)
+		req.SetQuestion(testQuery, dns.TypeA)
+		if _, err := f.ServeDNS(context.TODO(), &test.ResponseWriter{}, req); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if hitCount != 1 {
+		t.Errorf("expected the upstream to be queried once and the second answer served from cache, got %d upstream hits", hitCount)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2020 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fanout
+
+import (
+	"context"
+	"crypto/tls"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Network values a Client can be configured with. In addition to the plain
+// "udp"/"tcp" sockets miekg/dns already understands, fanout recognizes
+// "tcp-tls" (DNS-over-TLS, RFC 7858) and "https" (DNS-over-HTTPS, RFC 8484).
+const (
+	networkTLS   = "tcp-tls"
+	networkHTTPS = "https"
+)
+
+// Client represents a single upstream DNS server that fanout can query.
+type Client struct {
+	addr      string
+	net       string
+	tlsConfig *tls.Config
+	doh       *dohClient
+
+	// fails and ejectedUntil track this client's health-check state; both
+	// are accessed atomically since the health-checker and ServeDNS read
+	// and mutate them concurrently. See healthcheck.go.
+	fails        int32
+	ejectedUntil int64
+
+	// cache is shared with the owning Fanout; nil when caching is off.
+	// See cache.go.
+	cache *responseCache
+
+	// ecsPolicy and cookiesEnabled mirror the owning Fanout's settings and
+	// control how the outgoing EDNS0 OPT record is rewritten. See edns.go.
+	ecsPolicy      *ecsPolicy
+	cookiesEnabled bool
+}
+
+// NewClient returns a new Client for addr using the given network (udp, tcp
+// or tcp-tls).
+func NewClient(addr string, net string) *Client {
+	return &Client{
+		addr:           addr,
+		net:            net,
+		tlsConfig:      new(tls.Config),
+		ecsPolicy:      defaultECSPolicy,
+		cookiesEnabled: true,
+	}
+}
+
+// NewTLSClient returns a new Client that talks DNS-over-TLS to addr.
+func NewTLSClient(addr string, tlsConfig *tls.Config) *Client {
+	return &Client{
+		addr:           addr,
+		net:            networkTLS,
+		tlsConfig:      tlsConfig,
+		ecsPolicy:      defaultECSPolicy,
+		cookiesEnabled: true,
+	}
+}
+
+// NewDoHClient returns a new Client that talks DNS-over-HTTPS to dohURL, as
+// described in RFC 8484. bootstrap, when non-empty, is used to resolve
+// dohURL's host instead of recursing through fanout itself.
+func NewDoHClient(dohURL string, tlsConfig *tls.Config, bootstrap []string) (*Client, error) {
+	doh, err := newDoHClient(dohURL, tlsConfig, bootstrap)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		addr:           dohURL,
+		net:            networkHTTPS,
+		doh:            doh,
+		ecsPolicy:      defaultECSPolicy,
+		cookiesEnabled: true,
+	}, nil
+}
+
+// Exchange sends r to the upstream and returns its reply, serving a cached
+// answer instead when one is fresh.
+func (c *Client) Exchange(ctx context.Context, r *dns.Msg) (*dns.Msg, error) {
+	return c.doExchange(ctx, r, true)
+}
+
+// Probe sends r to the upstream for health-checking purposes. Unlike
+// Exchange it always goes to the network: a health-check probe cached from
+// the last successful tick would keep reporting a now-dead upstream as
+// healthy until the cached answer's TTL expired, silently defeating
+// ejection. See healthcheck.go.
+func (c *Client) Probe(ctx context.Context, r *dns.Msg) (*dns.Msg, error) {
+	return c.doExchange(ctx, r, false)
+}
+
+func (c *Client) doExchange(ctx context.Context, r *dns.Msg, useCache bool) (*dns.Msg, error) {
+	if useCache {
+		if cached, ok := c.cache.get(c.addr, r); ok {
+			return cached, nil
+		}
+	}
+
+	SocketsOpen.WithLabelValues(c.addr).Inc()
+	defer SocketsOpen.WithLabelValues(c.addr).Dec()
+
+	start := time.Now()
+	ret, err := c.exchange(ctx, r)
+	RequestDuration.WithLabelValues(c.addr).Observe(time.Since(start).Seconds())
+
+	qtype := ""
+	if len(r.Question) > 0 {
+		qtype = dns.Type(r.Question[0].Qtype).String()
+	}
+	rcode := "error"
+	if err == nil && ret != nil {
+		rcode = dns.RcodeToString[ret.Rcode]
+		ResponseRcodeCount.WithLabelValues(c.addr, rcode).Inc()
+		if useCache {
+			c.cache.set(c.addr, r, ret)
+		}
+	}
+	RequestCount.WithLabelValues(c.addr, qtype, rcode).Inc()
+
+	return ret, err
+}
+
+func (c *Client) exchange(ctx context.Context, r *dns.Msg) (*dns.Msg, error) {
+	r = c.prepareRequest(r)
+	if c.net == networkHTTPS {
+		return c.doh.exchange(ctx, r)
+	}
+	// A fresh *dns.Client per call, rather than one shared across goroutines:
+	// miekg/dns mutates its receiver's dialer cache in ExchangeContext, and
+	// ordinary dispatch and the health-checker both call exchange on the
+	// same Client concurrently.
+	dc := &dns.Client{Net: c.net, Timeout: defaultTimeout, TLSConfig: c.tlsConfig}
+	ret, _, err := dc.ExchangeContext(ctx, r, c.addr)
+	return ret, err
+}
+
+// Endpoint returns the upstream address this client talks to.
+func (c *Client) Endpoint() string {
+	return c.addr
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2020 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fanout
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/http2"
+)
+
+const dohMediaType = "application/dns-message"
+
+// dohClient implements DNS-over-HTTPS (RFC 8484) on top of a persistent
+// HTTP/2 connection pool.
+type dohClient struct {
+	url        string
+	httpClient *http.Client
+}
+
+// newDoHClient builds a dohClient for dohURL. When bootstrap is non-empty,
+// the URL's host is resolved against those resolvers directly instead of
+// recursing through fanout.
+func newDoHClient(dohURL string, tlsConfig *tls.Config, bootstrap []string) (*dohClient, error) {
+	if _, err := url.Parse(dohURL); err != nil {
+		return nil, fmt.Errorf("fanout: invalid doh url %q: %w", dohURL, err)
+	}
+
+	transport := &http2.Transport{TLSClientConfig: tlsConfig}
+	if len(bootstrap) > 0 {
+		resolver := &bootstrapResolver{servers: bootstrap}
+		transport.DialTLS = func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return resolver.dialTLS(network, addr, cfg)
+		}
+	}
+
+	return &dohClient{
+		url:        dohURL,
+		httpClient: &http.Client{Transport: transport, Timeout: defaultTimeout},
+	}, nil
+}
+
+func (d *dohClient) exchange(ctx context.Context, r *dns.Msg) (*dns.Msg, error) {
+	packed, err := r.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dohMediaType)
+	req.Header.Set("Accept", dohMediaType)
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { logErrIfNotNil(resp.Body.Close()) }()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fanout: doh upstream %s returned status %d", d.url, resp.StatusCode)
+	}
+
+	ret := new(dns.Msg)
+	if err := ret.Unpack(body); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// bootstrapResolver resolves DoH/DoT hostnames against a fixed list of
+// resolvers, so a fanout client never has to recurse through fanout itself
+// to find its own upstreams.
+type bootstrapResolver struct {
+	servers []string
+}
+
+func (b *bootstrapResolver) dialTLS(network, addr string, cfg *tls.Config) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ip, err := b.lookup(host)
+	if err != nil {
+		return nil, err
+	}
+	return tls.DialWithDialer(&net.Dialer{Timeout: defaultTimeout}, network, net.JoinHostPort(ip, port), cfg)
+}
+
+func (b *bootstrapResolver) lookup(host string) (string, error) {
+	if net.ParseIP(host) != nil {
+		return host, nil
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(host), dns.TypeA)
+
+	c := &dns.Client{Net: tcp, Timeout: defaultTimeout}
+	for _, server := range b.servers {
+		resp, _, err := c.Exchange(m, server)
+		if err != nil || resp == nil {
+			continue
+		}
+		for _, rr := range resp.Answer {
+			if a, ok := rr.(*dns.A); ok {
+				return a.A.String(), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("fanout: bootstrap resolvers could not resolve %q", host)
+}
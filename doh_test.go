@@ -0,0 +1,113 @@
+// Copyright (c) 2020 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fanout
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/http2"
+)
+
+// newDoHTestServer starts an HTTPS test server with HTTP/2 enabled, since
+// dohClient always speaks DoH over HTTP/2.
+func newDoHTestServer(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+
+	ts := httptest.NewUnstartedServer(handler)
+	if err := http2.ConfigureServer(ts.Config, new(http2.Server)); err != nil {
+		t.Fatal(err)
+	}
+	ts.TLS = ts.Config.TLSConfig
+	ts.StartTLS()
+	return ts
+}
+
+func TestDoHClientExchange(t *testing.T) {
+	ts := newDoHTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		req := new(dns.Msg)
+		if err := req.Unpack(body); err != nil {
+			t.Error(err)
+			return
+		}
+
+		msg := dns.Msg{Answer: []dns.RR{makeRecordA(testQuery + " 3600	IN	A 10.0.0.1")}}
+		msg.SetReply(req)
+		packed, err := msg.Pack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		w.Header().Set("Content-Type", dohMediaType)
+		if _, err := w.Write(packed); err != nil {
+			t.Error(err)
+		}
+	})
+	defer ts.Close()
+
+	doh, err := newDoHClient(ts.URL, &tls.Config{InsecureSkipVerify: true}, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion(testQuery, dns.TypeA)
+
+	resp, err := doh.exchange(context.Background(), req)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(resp.Answer) != 1 {
+		t.Fatalf("expected 1 answer, got %d", len(resp.Answer))
+	}
+	if a, ok := resp.Answer[0].(*dns.A); !ok || a.A.String() != "10.0.0.1" {
+		t.Errorf("expected an A record for 10.0.0.1, got %v", resp.Answer[0])
+	}
+}
+
+func TestBootstrapResolverLookup(t *testing.T) {
+	s := newServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := dns.Msg{Answer: []dns.RR{makeRecordA("upstream.example 3600	IN	A 10.1.2.3")}}
+		msg.SetReply(r)
+		logErrIfNotNil(w.WriteMsg(&msg))
+	})
+	defer s.close()
+
+	resolver := &bootstrapResolver{servers: []string{s.addr}}
+
+	ip, err := resolver.lookup("upstream.example.")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if ip != "10.1.2.3" {
+		t.Errorf("expected the bootstrap resolver to return 10.1.2.3, got %s", ip)
+	}
+
+	if ip, err := resolver.lookup("203.0.113.5"); err != nil || ip != "203.0.113.5" {
+		t.Errorf("expected a literal IP to pass through unchanged, got %q, %v", ip, err)
+	}
+}
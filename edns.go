@@ -0,0 +1,144 @@
+// Copyright (c) 2020 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fanout
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// ecsPolicyKind selects how fanout treats an EDNS0 client-subnet option on
+// its way to an upstream.
+type ecsPolicyKind int
+
+// Corefile directive values for ecs_policy.
+const (
+	ecsPassthrough = "passthrough"
+	ecsStrip       = "strip"
+	ecsOverride    = "override"
+)
+
+const (
+	ecsKindPassthrough ecsPolicyKind = iota
+	ecsKindStrip
+	ecsKindOverride
+)
+
+// ecsPolicy is the parsed form of the ecs_policy Corefile directive.
+// defaultECSPolicy (passthrough) keeps fanout's historical behavior of
+// forwarding whatever EDNS0 client-subnet option the client sent.
+var defaultECSPolicy = &ecsPolicy{kind: ecsKindPassthrough}
+
+type ecsPolicy struct {
+	kind   ecsPolicyKind
+	subnet *net.IPNet
+}
+
+// parseECSPolicy parses `passthrough`, `strip` or `override <cidr>`.
+func parseECSPolicy(kind string, cidr string) (*ecsPolicy, error) {
+	switch kind {
+	case ecsPassthrough:
+		return &ecsPolicy{kind: ecsKindPassthrough}, nil
+	case ecsStrip:
+		return &ecsPolicy{kind: ecsKindStrip}, nil
+	case ecsOverride:
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		return &ecsPolicy{kind: ecsKindOverride, subnet: subnet}, nil
+	default:
+		return nil, errUnknownECSPolicy
+	}
+}
+
+// prepareRequest applies c's ECS and cookie policy to r, returning a
+// (possibly) rewritten copy. r itself is never mutated since it is shared
+// across every client fanout dispatches to.
+func (c *Client) prepareRequest(r *dns.Msg) *dns.Msg {
+	if c.ecsPolicy.kind == ecsKindPassthrough && c.cookiesEnabled {
+		return r
+	}
+	opt := r.IsEdns0()
+	if opt == nil {
+		if c.ecsPolicy.kind != ecsKindOverride {
+			return r
+		}
+		// The client sent no OPT record at all, so there is nothing to
+		// rewrite — synthesize one carrying just the configured subnet.
+		out := r.Copy()
+		out.SetEdns0(dns.DefaultMsgSize, false)
+		outOpt := out.IsEdns0()
+		outOpt.Option = append(outOpt.Option, subnetOption(c.ecsPolicy.subnet))
+		return out
+	}
+
+	out := r.Copy()
+	outOpt := out.IsEdns0()
+
+	options := outOpt.Option[:0:0]
+	sawSubnet := false
+	for _, o := range outOpt.Option {
+		switch sub := o.(type) {
+		case *dns.EDNS0_SUBNET:
+			sawSubnet = true
+			if rewritten, keep := c.rewriteSubnet(sub); keep {
+				options = append(options, rewritten)
+			}
+		case *dns.EDNS0_COOKIE:
+			if c.cookiesEnabled {
+				options = append(options, o)
+			}
+		default:
+			options = append(options, o)
+		}
+	}
+	if !sawSubnet && c.ecsPolicy.kind == ecsKindOverride {
+		options = append(options, subnetOption(c.ecsPolicy.subnet))
+	}
+	outOpt.Option = options
+	return out
+}
+
+// rewriteSubnet returns the EDNS0_SUBNET option to forward given c's ECS
+// policy (sub is the option the client originally sent), and whether one
+// should be forwarded at all.
+func (c *Client) rewriteSubnet(sub *dns.EDNS0_SUBNET) (*dns.EDNS0_SUBNET, bool) {
+	switch c.ecsPolicy.kind {
+	case ecsKindStrip:
+		return nil, false
+	case ecsKindOverride:
+		return subnetOption(c.ecsPolicy.subnet), true
+	default:
+		return sub, true
+	}
+}
+
+func subnetOption(subnet *net.IPNet) *dns.EDNS0_SUBNET {
+	e := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET}
+	ones, _ := subnet.Mask.Size()
+	e.SourceNetmask = uint8(ones)
+	if ip4 := subnet.IP.To4(); ip4 != nil {
+		e.Family = 1
+		e.Address = ip4
+	} else {
+		e.Family = 2
+		e.Address = subnet.IP
+	}
+	return e
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2020 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fanout
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+)
+
+func withECSAndCookie(req *dns.Msg) *dns.Msg {
+	req.SetEdns0(4096, false)
+	opt := req.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{
+		Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: net4(192, 0, 2, 0),
+	})
+	opt.Option = append(opt.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "0001020304050607"})
+	return req
+}
+
+func net4(a, b, c, d byte) []byte { return []byte{a, b, c, d} }
+
+func answerAndCapture(t *testing.T, ecsArgs []string, cookies string) *dns.OPT {
+	t.Helper()
+
+	var mutex sync.Mutex
+	var captured *dns.OPT
+	s := newServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		mutex.Lock()
+		captured = r.IsEdns0()
+		mutex.Unlock()
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		logErrIfNotNil(w.WriteMsg(msg))
+	})
+	defer s.close()
+
+	f := New()
+	f.from = "."
+	if len(ecsArgs) > 0 {
+		cidr := ""
+		if len(ecsArgs) > 1 {
+			cidr = ecsArgs[1]
+		}
+		p, err := parseECSPolicy(ecsArgs[0], cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.ecsPolicy = p
+	}
+	if cookies == "off" {
+		f.cookiesEnabled = false
+	}
+	f.addClient(NewClient(s.addr, "tcp"))
+
+	req := withECSAndCookie(new(dns.Msg))
+	req.SetQuestion(testQuery, dns.TypeA)
+	if _, err := f.ServeDNS(context.TODO(), &test.ResponseWriter{}, req); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	return captured
+}
+
+func TestFanoutECSPassthroughByDefault(t *testing.T) {
+	opt := answerAndCapture(t, nil, "on")
+	if opt == nil || len(opt.Option) != 2 {
+		t.Fatalf("expected both the subnet and cookie options to be forwarded, got %v", opt)
+	}
+}
+
+func TestFanoutECSStrip(t *testing.T) {
+	opt := answerAndCapture(t, []string{ecsStrip}, "on")
+	for _, o := range opt.Option {
+		if _, ok := o.(*dns.EDNS0_SUBNET); ok {
+			t.Fatalf("expected the subnet option to be stripped, got %v", opt)
+		}
+	}
+}
+
+func TestFanoutECSOverride(t *testing.T) {
+	opt := answerAndCapture(t, []string{ecsOverride, "203.0.113.0/24"}, "on")
+	for _, o := range opt.Option {
+		if sub, ok := o.(*dns.EDNS0_SUBNET); ok {
+			if sub.SourceNetmask != 24 || sub.Address.String() != "203.0.113.0" {
+				t.Fatalf("expected the subnet option to be overridden to 203.0.113.0/24, got %v", sub)
+			}
+			return
+		}
+	}
+	t.Fatal("expected an overridden subnet option, found none")
+}
+
+func TestFanoutCookiesOff(t *testing.T) {
+	opt := answerAndCapture(t, nil, "off")
+	for _, o := range opt.Option {
+		if _, ok := o.(*dns.EDNS0_COOKIE); ok {
+			t.Fatalf("expected the cookie option to be stripped, got %v", opt)
+		}
+	}
+}
+
+func TestFanoutECSOverrideSynthesizesOptWithoutEdns0(t *testing.T) {
+	var mutex sync.Mutex
+	var captured *dns.OPT
+	s := newServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		mutex.Lock()
+		captured = r.IsEdns0()
+		mutex.Unlock()
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		logErrIfNotNil(w.WriteMsg(msg))
+	})
+	defer s.close()
+
+	f := New()
+	f.from = "."
+	p, err := parseECSPolicy(ecsOverride, "203.0.113.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.ecsPolicy = p
+	f.addClient(NewClient(s.addr, "tcp"))
+
+	req := new(dns.Msg)
+	req.SetQuestion(testQuery, dns.TypeA)
+	if req.IsEdns0() != nil {
+		t.Fatal("expected the query to start without an OPT record")
+	}
+	if _, err := f.ServeDNS(context.TODO(), &test.ResponseWriter{}, req); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	mutex.Lock()
+	opt := captured
+	mutex.Unlock()
+
+	if opt == nil {
+		t.Fatal("expected an OPT record to be synthesized for the override policy, got none")
+	}
+	for _, o := range opt.Option {
+		if sub, ok := o.(*dns.EDNS0_SUBNET); ok {
+			if sub.SourceNetmask != 24 || sub.Address.String() != "203.0.113.0" {
+				t.Fatalf("expected the subnet option to be overridden to 203.0.113.0/24, got %v", sub)
+			}
+			return
+		}
+	}
+	t.Fatal("expected a synthesized subnet option, found none")
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2020 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fanout implements a plugin that sends a query to multiple upstream
+// resolvers and answers with the reply its configured policy selects (by
+// default, the first usable one).
+package fanout
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	clog "github.com/coredns/coredns/plugin/pkg/log"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+)
+
+var log = clog.NewWithPlugin("fanout")
+
+const (
+	tcp = "tcp"
+	udp = "udp"
+
+	defaultTimeout     = 2 * time.Second
+	defaultWorkerCount = 2
+	minWorkerCount     = 1
+)
+
+var (
+	errNoClients        = errors.New("fanout: no clients configured")
+	errUnknownPolicy    = errors.New("fanout: unknown policy")
+	errUnknownECSPolicy = errors.New("fanout: unknown ecs_policy")
+)
+
+// Fanout represents a plugin instance that can do several lookups in parallel
+// and answers with the response its policy selects (by default, the first
+// one that looks usable).
+type Fanout struct {
+	clients     []*Client
+	tlsConfig   *tls.Config
+	timeout     time.Duration
+	net         string
+	from        string
+	workerCount int
+	bootstrap   []string
+	policy      Policy
+
+	healthCheckInterval time.Duration
+	healthCheckDomain   string
+	maxFails            int32
+	expire              time.Duration
+	stopCh              chan struct{}
+
+	cache *responseCache
+
+	ecsPolicy      *ecsPolicy
+	cookiesEnabled bool
+
+	queryLog QueryLogger
+	logCh    chan QueryLogRecord
+	logDone  chan struct{}
+
+	Next plugin.Handler
+}
+
+// New returns a new Fanout with sane defaults.
+func New() *Fanout {
+	return &Fanout{
+		tlsConfig:         new(tls.Config),
+		timeout:           defaultTimeout,
+		workerCount:       defaultWorkerCount,
+		net:               udp,
+		policy:            policies[policyFirst],
+		healthCheckDomain: defaultHealthCheckDomain,
+		maxFails:          defaultMaxFails,
+		expire:            defaultExpire,
+		stopCh:            make(chan struct{}),
+		ecsPolicy:         defaultECSPolicy,
+		cookiesEnabled:    true,
+	}
+}
+
+// addClient appends an upstream client to the fan-out set.
+func (f *Fanout) addClient(c *Client) {
+	c.cache = f.cache
+	c.ecsPolicy = f.ecsPolicy
+	c.cookiesEnabled = f.cookiesEnabled
+	f.clients = append(f.clients, c)
+}
+
+// Name implements the plugin.Handler interface.
+func (f *Fanout) Name() string {
+	return "fanout"
+}
+
+type response struct {
+	client *Client
+	msg    *dns.Msg
+	err    error
+	rtt    time.Duration
+}
+
+// ServeDNS implements the plugin.Handler interface.
+func (f *Fanout) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	state := request.Request{W: w, Req: r}
+	if !plugin.Name(f.from).Matches(state.Name()) {
+		return plugin.NextOrFailure(f.Name(), f.Next, ctx, w, r)
+	}
+
+	start := time.Now()
+
+	clients := f.healthyClients()
+	if len(clients) == 0 {
+		return dns.RcodeServerFailure, errNoClients
+	}
+
+	res, err := f.policy.Query(ctx, f, clients, r)
+	if err != nil {
+		f.logQuery(state, nil, time.Since(start))
+		return dns.RcodeServerFailure, err
+	}
+	f.logQuery(state, res, time.Since(start))
+	return f.finalize(w, res.msg)
+}
+
+// dispatch fans the request out to clients using a bounded worker pool and
+// returns a channel that receives one response per client.
+func (f *Fanout) dispatch(ctx context.Context, clients []*Client, r *dns.Msg) chan *response {
+	tasks := make(chan *Client, len(clients))
+	for _, c := range clients {
+		tasks <- c
+	}
+	close(tasks)
+
+	workerCount := f.workerCount
+	if workerCount > len(clients) {
+		workerCount = len(clients)
+	}
+	if workerCount < minWorkerCount {
+		workerCount = minWorkerCount
+	}
+
+	results := make(chan *response, len(clients))
+	var wg sync.WaitGroup
+	wg.Add(workerCount)
+	for i := 0; i < workerCount; i++ {
+		go func() {
+			defer wg.Done()
+			for c := range tasks {
+				start := time.Now()
+				msg, err := c.Exchange(ctx, r)
+				results <- &response{client: c, msg: msg, err: err, rtt: time.Since(start)}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+func (f *Fanout) finalize(w dns.ResponseWriter, m *dns.Msg) (int, error) {
+	if err := w.WriteMsg(m); err != nil {
+		return dns.RcodeServerFailure, err
+	}
+	return dns.RcodeSuccess, nil
+}
+
+func isSuccessResponse(m *dns.Msg) bool {
+	return m != nil && m.Rcode == dns.RcodeSuccess
+}
+
+func logErrIfNotNil(err error) {
+	if err != nil {
+		log.Warning(err)
+	}
+}
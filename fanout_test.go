@@ -24,7 +24,7 @@ import (
 	"testing"
 	"time"
 
-	"github.com/caddyserver/caddy"
+	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/plugin/pkg/dnstest"
 	"github.com/coredns/coredns/plugin/test"
 	"github.com/miekg/dns"
@@ -84,73 +84,90 @@ func makeRecordA(rr string) *dns.A {
 }
 
 func TestFanoutCanReturnUnsuccessRespnse(t *testing.T) {
-	s := newServer(func(w dns.ResponseWriter, r *dns.Msg) {
-		msg := nxdomainMsg()
-		msg.SetRcode(r, msg.Rcode)
-		logErrIfNotNil(w.WriteMsg(msg))
-	})
-	f := New()
-	f.from = "."
-	c := NewClient(s.addr, "tcp")
-	f.addClient(c)
-	req := new(dns.Msg)
-	req.SetQuestion(testQuery, dns.TypeA)
-	writer := &cachedDNSWriter{ResponseWriter: new(test.ResponseWriter)}
-	_, err := f.ServeDNS(context.TODO(), writer, req)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if len(writer.answers) != 1 {
-		t.FailNow()
-	}
-	if writer.answers[0].MsgHdr.Rcode != dns.RcodeNameError {
-		t.Error("fanout plugin returns first negative answer if other answers on request are negative")
+	for _, policyName := range []string{policyFirst, policySequential, policyRace, policyBest} {
+		policyName := policyName
+		t.Run(policyName, func(t *testing.T) {
+			s := newServer(func(w dns.ResponseWriter, r *dns.Msg) {
+				msg := nxdomainMsg()
+				msg.SetRcode(r, msg.Rcode)
+				logErrIfNotNil(w.WriteMsg(msg))
+			})
+			defer s.close()
+			f := New()
+			f.from = "."
+			f.policy = policies[policyName]
+			c := NewClient(s.addr, "tcp")
+			f.addClient(c)
+			req := new(dns.Msg)
+			req.SetQuestion(testQuery, dns.TypeA)
+			writer := &cachedDNSWriter{ResponseWriter: new(test.ResponseWriter)}
+			_, err := f.ServeDNS(context.TODO(), writer, req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(writer.answers) != 1 {
+				t.FailNow()
+			}
+			if writer.answers[0].MsgHdr.Rcode != dns.RcodeNameError {
+				t.Error("fanout plugin returns the negative answer when no upstream has a positive one")
+			}
+		})
 	}
 }
+
+// TestFanoutTwoServersNotSuccessResponse checks that, for policies expected
+// to prefer a positive answer over a negative one, fanout never hands back
+// the negative response as long as some upstream answered positively.
 func TestFanoutTwoServersNotSuccessResponse(t *testing.T) {
-	rcode := 1
-	rcodeMutex := sync.Mutex{}
-	s1 := newServer(func(w dns.ResponseWriter, r *dns.Msg) {
-		if r.Question[0].Name == testQuery {
-			msg := nxdomainMsg()
-			rcodeMutex.Lock()
-			msg.SetRcode(r, rcode)
-			rcode++
-			rcode %= dns.RcodeNotZone
-			rcodeMutex.Unlock()
-			logErrIfNotNil(w.WriteMsg(msg))
-		}
-	})
-	s2 := newServer(func(w dns.ResponseWriter, r *dns.Msg) {
-		if r.Question[0].Name == testQuery {
-			msg := dns.Msg{
-				Answer: []dns.RR{makeRecordA("example1. 3600	IN	A 10.0.0.1")},
+	for _, policyName := range []string{policyFirst, policyBest} {
+		policyName := policyName
+		t.Run(policyName, func(t *testing.T) {
+			rcode := 1
+			rcodeMutex := sync.Mutex{}
+			s1 := newServer(func(w dns.ResponseWriter, r *dns.Msg) {
+				if r.Question[0].Name == testQuery {
+					msg := nxdomainMsg()
+					rcodeMutex.Lock()
+					msg.SetRcode(r, rcode)
+					rcode++
+					rcode %= dns.RcodeNotZone
+					rcodeMutex.Unlock()
+					logErrIfNotNil(w.WriteMsg(msg))
+				}
+			})
+			s2 := newServer(func(w dns.ResponseWriter, r *dns.Msg) {
+				if r.Question[0].Name == testQuery {
+					msg := dns.Msg{
+						Answer: []dns.RR{makeRecordA("example1. 3600	IN	A 10.0.0.1")},
+					}
+					msg.SetReply(r)
+					logErrIfNotNil(w.WriteMsg(&msg))
+				}
+			})
+			defer s1.close()
+			defer s2.close()
+			c1 := NewClient(s1.addr, "tcp")
+			c2 := NewClient(s2.addr, "tcp")
+			f := New()
+			f.from = "."
+			f.policy = policies[policyName]
+			f.addClient(c1)
+			f.addClient(c2)
+			writer := &cachedDNSWriter{ResponseWriter: new(test.ResponseWriter)}
+			for i := 0; i < 10; i++ {
+				req := new(dns.Msg)
+				req.SetQuestion(testQuery, dns.TypeA)
+				_, err := f.ServeDNS(context.TODO(), writer, req)
+				if err != nil {
+					t.Fatal(err.Error())
+				}
 			}
-			msg.SetReply(r)
-			logErrIfNotNil(w.WriteMsg(&msg))
-		}
-	})
-	defer s1.close()
-	defer s2.close()
-	c1 := NewClient(s1.addr, "tcp")
-	c2 := NewClient(s2.addr, "tcp")
-	f := New()
-	f.from = "."
-	f.addClient(c1)
-	f.addClient(c2)
-	writer := &cachedDNSWriter{ResponseWriter: new(test.ResponseWriter)}
-	for i := 0; i < 10; i++ {
-		req := new(dns.Msg)
-		req.SetQuestion(testQuery, dns.TypeA)
-		_, err := f.ServeDNS(context.TODO(), writer, req)
-		if err != nil {
-			t.Fatal(err.Error())
-		}
-	}
-	for _, m := range writer.answers {
-		if m.MsgHdr.Rcode != dns.RcodeSuccess {
-			t.Error("fanout should return only positive answers")
-		}
+			for _, m := range writer.answers {
+				if m.MsgHdr.Rcode != dns.RcodeSuccess {
+					t.Error("fanout should return only positive answers")
+				}
+			}
+		})
 	}
 }
 
@@ -213,6 +230,9 @@ func TestFanoutTwoServers(t *testing.T) {
 	}
 }
 
+// TestFanouWorkerCountLessThenServers checks that non-responsive upstreams
+// get ejected by the health-checker, leaving one healthy client, and that a
+// query with a single worker is then answered once by that healthy upstream.
 func TestFanouWorkerCountLessThenServers(t *testing.T) {
 	const expected = 1
 	answerCount1 := 0
@@ -226,6 +246,9 @@ func TestFanouWorkerCountLessThenServers(t *testing.T) {
 	defer free()
 	f := New()
 	f.from = "."
+	f.timeout = 50 * time.Millisecond
+	f.healthCheckInterval = 10 * time.Millisecond
+	f.maxFails = 1
 
 	for i := 0; i < 4; i++ {
 		incorrectServer := newServer(func(w dns.ResponseWriter, r *dns.Msg) {
@@ -243,18 +266,34 @@ func TestFanouWorkerCountLessThenServers(t *testing.T) {
 			mutex.Unlock()
 			msg.SetReply(r)
 			logErrIfNotNil(w.WriteMsg(&msg))
+			return
+		}
+		if r.Question[0].Qtype == dns.TypeNS {
+			msg := new(dns.Msg)
+			msg.SetReply(r)
+			logErrIfNotNil(w.WriteMsg(msg))
 		}
 	})
 
 	f.addClient(NewClient(correctServer.addr, "tcp"))
 	f.workerCount = 1
+
+	if err := f.OnStartup(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer func() { logErrIfNotNil(f.OnShutdown()) }()
+
+	<-time.After(200 * time.Millisecond)
+	if healthy := f.healthyClients(); len(healthy) != 1 {
+		t.Fatalf("expected non-responsive upstreams to be ejected, got %d healthy clients", len(healthy))
+	}
+
 	req := new(dns.Msg)
 	req.SetQuestion(testQuery, dns.TypeA)
 	_, err := f.ServeDNS(context.TODO(), &test.ResponseWriter{}, req)
 	if err != nil {
 		t.FailNow()
 	}
-	<-time.After(time.Second)
 	mutex.Lock()
 	defer mutex.Unlock()
 	if answerCount1 != expected {
@@ -0,0 +1,106 @@
+// Copyright (c) 2020 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fanout
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Defaults for the active health-checker. Health-checking is off unless a
+// health_check interval is configured.
+const (
+	defaultMaxFails          = int32(2)
+	defaultExpire            = 10 * time.Second
+	defaultHealthCheckDomain = "."
+)
+
+// healthCheckLoop periodically probes every configured client until f is
+// shut down. It is started from OnStartup and only runs when a health-check
+// interval was configured.
+func (f *Fanout) healthCheckLoop() {
+	ticker := time.NewTicker(f.healthCheckInterval)
+	defer ticker.Stop()
+
+	probe := new(dns.Msg)
+	probe.SetQuestion(dns.Fqdn(f.healthCheckDomain), dns.TypeNS)
+
+	for {
+		select {
+		case <-ticker.C:
+			f.checkClients(probe)
+		case <-f.stopCh:
+			return
+		}
+	}
+}
+
+func (f *Fanout) checkClients(probe *dns.Msg) {
+	for _, c := range f.clients {
+		c := c
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+			defer cancel()
+			_, err := c.Probe(ctx, probe)
+			c.recordHealthCheck(err, f.maxFails, f.expire)
+		}()
+	}
+}
+
+// healthyClients returns the subset of f.clients that are not currently
+// ejected. If health-checking is disabled every client is considered
+// healthy.
+func (f *Fanout) healthyClients() []*Client {
+	if f.healthCheckInterval <= 0 {
+		return f.clients
+	}
+	healthy := make([]*Client, 0, len(f.clients))
+	for _, c := range f.clients {
+		if c.Healthy() {
+			healthy = append(healthy, c)
+		}
+	}
+	return healthy
+}
+
+// Healthy reports whether c is currently eligible for the fan-out set.
+func (c *Client) Healthy() bool {
+	ejectedUntil := atomic.LoadInt64(&c.ejectedUntil)
+	if ejectedUntil == 0 {
+		return true
+	}
+	return time.Now().UnixNano() >= ejectedUntil
+}
+
+// recordHealthCheck updates c's rolling failure count and ejects it once
+// maxFails consecutive probes have failed. A successful probe immediately
+// clears any ejection.
+func (c *Client) recordHealthCheck(err error, maxFails int32, expire time.Duration) {
+	if err != nil {
+		HealthcheckFailureCount.WithLabelValues(c.addr).Inc()
+		if atomic.AddInt32(&c.fails, 1) >= maxFails {
+			atomic.StoreInt64(&c.ejectedUntil, time.Now().Add(expire).UnixNano())
+			c.cache.evict(c.addr)
+		}
+		return
+	}
+	atomic.StoreInt32(&c.fails, 0)
+	atomic.StoreInt64(&c.ejectedUntil, 0)
+}
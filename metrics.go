@@ -0,0 +1,65 @@
+// Copyright (c) 2020 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fanout
+
+import (
+	"github.com/coredns/coredns/plugin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics exported by the fanout plugin, in the coredns_fanout_* namespace.
+// promauto registers each collector with prometheus.DefaultRegisterer
+// exactly once, at package init, so setup() doesn't need to (and must not)
+// register them again on every Corefile reload.
+var (
+	RequestCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "fanout",
+		Name:      "request_count_total",
+		Help:      "Counter of requests made per upstream, query type and rcode.",
+	}, []string{"to", "type", "rcode"})
+
+	RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "fanout",
+		Name:      "request_duration_seconds",
+		Buckets:   plugin.TimeBuckets,
+		Help:      "Histogram of the time each upstream request took.",
+	}, []string{"to"})
+
+	ResponseRcodeCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "fanout",
+		Name:      "response_rcode_count_total",
+		Help:      "Counter of responses per upstream and rcode.",
+	}, []string{"to", "rcode"})
+
+	HealthcheckFailureCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "fanout",
+		Name:      "healthcheck_failures_total",
+		Help:      "Counter of failed health-check probes per upstream.",
+	}, []string{"to"})
+
+	SocketsOpen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "fanout",
+		Name:      "sockets_open",
+		Help:      "Gauge of in-flight upstream connections.",
+	}, []string{"to"})
+)
@@ -0,0 +1,132 @@
+// Copyright (c) 2020 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fanout
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestFanoutMetricsRequestCount drives two upstreams, as in
+// TestFanoutTwoServers, and checks that every collector fanout exports keeps
+// an independent series per "to" label value rather than conflating the two
+// upstreams.
+func TestFanoutMetricsRequestCount(t *testing.T) {
+	s1 := newServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		if r.Question[0].Name == testQuery {
+			msg := dns.Msg{
+				Answer: []dns.RR{makeRecordA("example1. 3600	IN	A 10.0.0.1")},
+			}
+			msg.SetReply(r)
+			logErrIfNotNil(w.WriteMsg(&msg))
+		}
+	})
+	defer s1.close()
+	s2 := newServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		if r.Question[0].Name == "example2." {
+			msg := nxdomainMsg()
+			msg.SetRcode(r, dns.RcodeNameError)
+			logErrIfNotNil(w.WriteMsg(msg))
+		}
+	})
+	defer s2.close()
+
+	RequestCount.Reset()
+	ResponseRcodeCount.Reset()
+	RequestDuration.Reset()
+	SocketsOpen.Reset()
+
+	f := New()
+	f.from = "."
+	f.addClient(NewClient(s1.addr, "tcp"))
+	f.addClient(NewClient(s2.addr, "tcp"))
+
+	req := new(dns.Msg)
+	req.SetQuestion(testQuery, dns.TypeA)
+	if _, err := f.ServeDNS(context.TODO(), &test.ResponseWriter{}, req); err != nil {
+		t.Fatal(err.Error())
+	}
+	req = new(dns.Msg)
+	req.SetQuestion("example2.", dns.TypeA)
+	if _, err := f.ServeDNS(context.TODO(), &test.ResponseWriter{}, req); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if got := testutil.ToFloat64(RequestCount.WithLabelValues(s1.addr, "A", "NOERROR")); got != 1 {
+		t.Errorf("expected request_count_total{to=%q,type=A,rcode=NOERROR} to be 1, got %v", s1.addr, got)
+	}
+	if got := testutil.ToFloat64(RequestCount.WithLabelValues(s2.addr, "A", "NXDOMAIN")); got != 1 {
+		t.Errorf("expected request_count_total{to=%q,type=A,rcode=NXDOMAIN} to be 1, got %v", s2.addr, got)
+	}
+	if got := testutil.ToFloat64(ResponseRcodeCount.WithLabelValues(s1.addr, "NOERROR")); got != 1 {
+		t.Errorf("expected response_rcode_count_total{to=%q,rcode=NOERROR} to be 1, got %v", s1.addr, got)
+	}
+	if got := testutil.ToFloat64(ResponseRcodeCount.WithLabelValues(s2.addr, "NXDOMAIN")); got != 1 {
+		t.Errorf("expected response_rcode_count_total{to=%q,rcode=NXDOMAIN} to be 1, got %v", s2.addr, got)
+	}
+
+	// Each upstream got its own request, so each must have its own
+	// request_duration_seconds series.
+	if got := testutil.CollectAndCount(RequestDuration); got != 2 {
+		t.Errorf("expected request_duration_seconds to have 2 series, got %d", got)
+	}
+
+	// SocketsOpen increments around the network call and decrements again
+	// once it returns, so by the time ServeDNS has returned it should be
+	// back to 0 for both upstreams, each with its own series.
+	if got := testutil.CollectAndCount(SocketsOpen); got != 2 {
+		t.Errorf("expected sockets_open to have 2 series, got %d", got)
+	}
+	if got := testutil.ToFloat64(SocketsOpen.WithLabelValues(s1.addr)); got != 0 {
+		t.Errorf("expected sockets_open{to=%q} to settle back to 0, got %v", s1.addr, got)
+	}
+	if got := testutil.ToFloat64(SocketsOpen.WithLabelValues(s2.addr)); got != 0 {
+		t.Errorf("expected sockets_open{to=%q} to settle back to 0, got %v", s2.addr, got)
+	}
+}
+
+// TestFanoutMetricsHealthcheckFailureCount checks that a non-responsive
+// upstream's failed probes are counted, using the same ejection setup as
+// TestFanouWorkerCountLessThenServers.
+func TestFanoutMetricsHealthcheckFailureCount(t *testing.T) {
+	HealthcheckFailureCount.Reset()
+
+	s := newServer(func(w dns.ResponseWriter, r *dns.Msg) {})
+	defer s.close()
+
+	f := New()
+	f.from = "."
+	f.timeout = 50 * time.Millisecond
+	f.healthCheckInterval = 10 * time.Millisecond
+	f.maxFails = 1
+	f.addClient(NewClient(s.addr, "tcp"))
+
+	if err := f.OnStartup(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer func() { logErrIfNotNil(f.OnShutdown()) }()
+
+	<-time.After(100 * time.Millisecond)
+	if got := testutil.ToFloat64(HealthcheckFailureCount.WithLabelValues(s.addr)); got < 1 {
+		t.Errorf("expected healthcheck_failures_total{to=%q} to be >= 1, got %v", s.addr, got)
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2020 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fanout
+
+import (
+	"context"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Policy names accepted by the `policy` Corefile directive.
+const (
+	policyFirst      = "first"
+	policySequential = "sequential"
+	policyRace       = "race"
+	policyBest       = "best"
+)
+
+// Policy decides which of the upstream responses ServeDNS hands back to the
+// client.
+type Policy interface {
+	// Name is the Corefile directive value that selects this policy.
+	Name() string
+	// Query dispatches r to clients and selects a single response, along
+	// with the client that produced it.
+	Query(ctx context.Context, f *Fanout, clients []*Client, r *dns.Msg) (*response, error)
+}
+
+// policies maps Corefile directive values to Policy implementations.
+var policies = map[string]Policy{
+	policyFirst:      &firstPolicy{},
+	policySequential: &sequentialPolicy{},
+	policyRace:       &racePolicy{},
+	policyBest:       &bestPolicy{},
+}
+
+// parsePolicy looks up a Policy by its Corefile directive name.
+func parsePolicy(name string) (Policy, error) {
+	p, ok := policies[name]
+	if !ok {
+		return nil, errUnknownPolicy
+	}
+	return p, nil
+}
+
+// firstPolicy returns the first positive response seen, falling back to the
+// first negative one if none of the upstreams answered positively. This is
+// fanout's original, default behavior.
+type firstPolicy struct{}
+
+func (p *firstPolicy) Name() string { return policyFirst }
+
+func (p *firstPolicy) Query(ctx context.Context, f *Fanout, clients []*Client, r *dns.Msg) (*response, error) {
+	results := f.dispatch(ctx, clients, r)
+
+	var negative *response
+	for res := range results {
+		if res.err != nil {
+			continue
+		}
+		if isSuccessResponse(res.msg) {
+			return res, nil
+		}
+		if negative == nil {
+			negative = res
+		}
+	}
+	if negative != nil {
+		return negative, nil
+	}
+	return nil, errNoClients
+}
+
+// racePolicy returns whichever upstream answers first, regardless of rcode.
+type racePolicy struct{}
+
+func (p *racePolicy) Name() string { return policyRace }
+
+func (p *racePolicy) Query(ctx context.Context, f *Fanout, clients []*Client, r *dns.Msg) (*response, error) {
+	results := f.dispatch(ctx, clients, r)
+
+	for res := range results {
+		if res.err != nil {
+			continue
+		}
+		return res, nil
+	}
+	return nil, errNoClients
+}
+
+// sequentialPolicy tries upstreams in the order they were configured,
+// stopping at the first one that answers without a transport error.
+type sequentialPolicy struct{}
+
+func (p *sequentialPolicy) Name() string { return policySequential }
+
+func (p *sequentialPolicy) Query(ctx context.Context, f *Fanout, clients []*Client, r *dns.Msg) (*response, error) {
+	for _, c := range clients {
+		start := time.Now()
+		msg, err := c.Exchange(ctx, r)
+		if err != nil {
+			continue
+		}
+		return &response{client: c, msg: msg, rtt: time.Since(start)}, nil
+	}
+	return nil, errNoClients
+}
+
+// rcodePriority ranks rcodes so bestPolicy can prefer NOERROR over NXDOMAIN
+// over SERVFAIL over anything else.
+func rcodePriority(rcode int) int {
+	switch rcode {
+	case dns.RcodeSuccess:
+		return 0
+	case dns.RcodeNameError:
+		return 1
+	case dns.RcodeServerFailure:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// bestPolicy waits for every upstream to answer (or fail) and picks the
+// response with the best rcode, breaking ties by the lowest latency.
+type bestPolicy struct{}
+
+func (p *bestPolicy) Name() string { return policyBest }
+
+func (p *bestPolicy) Query(ctx context.Context, f *Fanout, clients []*Client, r *dns.Msg) (*response, error) {
+	results := f.dispatch(ctx, clients, r)
+
+	var best *response
+	for res := range results {
+		if res.err != nil {
+			continue
+		}
+		switch {
+		case best == nil:
+			best = res
+		case rcodePriority(res.msg.Rcode) < rcodePriority(best.msg.Rcode):
+			best = res
+		case rcodePriority(res.msg.Rcode) == rcodePriority(best.msg.Rcode) && res.rtt < best.rtt:
+			best = res
+		}
+	}
+	if best != nil {
+		return best, nil
+	}
+	return nil, errNoClients
+}
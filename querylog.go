@@ -0,0 +1,160 @@
+// Copyright (c) 2020 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fanout
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+)
+
+var errInvalidQueryLogArgs = errors.New("fanout: log expects \"stdout\", \"syslog\", or \"file <path> [max_bytes]\"")
+
+// queryLogChanSize bounds how many pending records the drain goroutine can
+// fall behind by. A full channel drops the record rather than blocking
+// ServeDNS.
+const queryLogChanSize = 4096
+
+// QueryLogRecord is one structured record emitted per request.
+type QueryLogRecord struct {
+	ClientIP       string  `json:"client_ip"`
+	Qname          string  `json:"qname"`
+	Qtype          string  `json:"qtype"`
+	Upstream       string  `json:"upstream"`
+	Rcode          string  `json:"rcode"`
+	AnswerCount    int     `json:"answer_count"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+}
+
+// QueryLogger is a pluggable sink for query log records.
+type QueryLogger interface {
+	Log(rec QueryLogRecord) error
+	Close() error
+}
+
+// jsonLineLogger writes one JSON object per line to an underlying writer.
+// It backs both the stdout and file sinks.
+type jsonLineLogger struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	out io.Closer
+}
+
+func newStdoutQueryLogger() QueryLogger {
+	return &jsonLineLogger{enc: json.NewEncoder(os.Stdout)}
+}
+
+// parseQueryLogger builds a QueryLogger from the arguments of a `log`
+// Corefile directive: "stdout", "syslog", or "file <path> [max_bytes]".
+func parseQueryLogger(args []string) (QueryLogger, error) {
+	switch strings.ToLower(args[0]) {
+	case "stdout":
+		return newStdoutQueryLogger(), nil
+	case "syslog":
+		return newSyslogQueryLogger()
+	case "file":
+		if len(args) < 2 {
+			return nil, errInvalidQueryLogArgs
+		}
+		maxBytes := int64(defaultLogRotateSize)
+		if len(args) > 2 {
+			n, err := strconv.ParseInt(args[2], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			maxBytes = n
+		}
+		return newFileQueryLogger(args[1], maxBytes)
+	default:
+		return nil, errInvalidQueryLogArgs
+	}
+}
+
+func (l *jsonLineLogger) Log(rec QueryLogRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.enc.Encode(rec)
+}
+
+func (l *jsonLineLogger) Close() error {
+	if l.out == nil {
+		return nil
+	}
+	return l.out.Close()
+}
+
+// startQueryLog starts the drain goroutine that writes records from f.logCh
+// to f.queryLog. It is a no-op if no query logger is configured.
+func (f *Fanout) startQueryLog() {
+	if f.queryLog == nil {
+		return
+	}
+	f.logCh = make(chan QueryLogRecord, queryLogChanSize)
+	f.logDone = make(chan struct{})
+	go func() {
+		defer close(f.logDone)
+		for rec := range f.logCh {
+			logErrIfNotNil(f.queryLog.Log(rec))
+		}
+	}()
+}
+
+// stopQueryLog closes the drain goroutine and flushes the query logger. It
+// is a no-op if no query logger is configured.
+func (f *Fanout) stopQueryLog() error {
+	if f.queryLog == nil {
+		return nil
+	}
+	close(f.logCh)
+	<-f.logDone
+	return f.queryLog.Close()
+}
+
+// logQuery builds a QueryLogRecord for the request/response pair and hands
+// it to the drain goroutine without blocking ServeDNS. A full channel drops
+// the record. res is nil when every upstream failed.
+func (f *Fanout) logQuery(state request.Request, res *response, elapsed time.Duration) {
+	if f.logCh == nil {
+		return
+	}
+
+	rec := QueryLogRecord{
+		ClientIP:       state.IP(),
+		Qname:          state.Name(),
+		Qtype:          state.Type(),
+		Rcode:          dns.RcodeToString[dns.RcodeServerFailure],
+		ElapsedSeconds: elapsed.Seconds(),
+	}
+	if res != nil {
+		rec.Upstream = res.client.Endpoint()
+		rec.Rcode = dns.RcodeToString[res.msg.Rcode]
+		rec.AnswerCount = len(res.msg.Answer)
+	}
+
+	select {
+	case f.logCh <- rec:
+	default:
+	}
+}
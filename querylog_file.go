@@ -0,0 +1,99 @@
+// Copyright (c) 2020 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fanout
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// defaultLogRotateSize is the size a log file is allowed to reach before
+// fileQueryLogger rotates it out to a single ".1" backup.
+const defaultLogRotateSize = 100 * 1024 * 1024 // 100MB
+
+// fileQueryLogger writes JSON lines to path, rotating to a single backup
+// once the file grows past maxBytes.
+type fileQueryLogger struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	size     int64
+	f        *os.File
+	enc      *json.Encoder
+}
+
+func newFileQueryLogger(path string, maxBytes int64) (QueryLogger, error) {
+	l := &fileQueryLogger{path: path, maxBytes: maxBytes}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *fileQueryLogger) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		logErrIfNotNil(f.Close())
+		return err
+	}
+	l.f = f
+	l.size = info.Size()
+	l.enc = json.NewEncoder(f)
+	return nil
+}
+
+func (l *fileQueryLogger) Log(rec QueryLogRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.maxBytes > 0 && l.size >= l.maxBytes {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	before := l.size
+	if err := l.enc.Encode(rec); err != nil {
+		return err
+	}
+	if info, err := l.f.Stat(); err == nil {
+		l.size = info.Size()
+	} else {
+		l.size = before
+	}
+	return nil
+}
+
+func (l *fileQueryLogger) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return l.open()
+}
+
+func (l *fileQueryLogger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2020 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !windows
+
+package fanout
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+// syslogQueryLogger writes one JSON object per line to the local syslog
+// daemon, at the info priority under the "fanout" tag.
+type syslogQueryLogger struct {
+	w *syslog.Writer
+}
+
+func newSyslogQueryLogger() (QueryLogger, error) {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, "fanout")
+	if err != nil {
+		return nil, err
+	}
+	return &syslogQueryLogger{w: w}, nil
+}
+
+func (l *syslogQueryLogger) Log(rec QueryLogRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return l.w.Info(string(b))
+}
+
+func (l *syslogQueryLogger) Close() error {
+	return l.w.Close()
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2020 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fanout
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+)
+
+// recordingQueryLogger is a QueryLogger that keeps every record in memory,
+// for assertions in tests.
+type recordingQueryLogger struct {
+	mu      sync.Mutex
+	records []QueryLogRecord
+}
+
+func (l *recordingQueryLogger) Log(rec QueryLogRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records = append(l.records, rec)
+	return nil
+}
+
+func (l *recordingQueryLogger) Close() error { return nil }
+
+func (l *recordingQueryLogger) all() []QueryLogRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]QueryLogRecord(nil), l.records...)
+}
+
+func TestFanoutQueryLogSuccessAndNXDOMAIN(t *testing.T) {
+	s := newServer(func(w dns.ResponseWriter, r *dns.Msg) {
+		if r.Question[0].Name == testQuery {
+			msg := dns.Msg{Answer: []dns.RR{makeRecordA("example1 3600	IN	A 10.0.0.1")}}
+			msg.SetReply(r)
+			logErrIfNotNil(w.WriteMsg(&msg))
+			return
+		}
+		msg := nxdomainMsg()
+		msg.SetRcode(r, msg.Rcode)
+		logErrIfNotNil(w.WriteMsg(msg))
+	})
+	defer s.close()
+
+	recorder := &recordingQueryLogger{}
+	f := New()
+	f.from = "."
+	f.queryLog = recorder
+	f.addClient(NewClient(s.addr, "tcp"))
+
+	if err := f.OnStartup(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	okReq := new(dns.Msg)
+	okReq.SetQuestion(testQuery, dns.TypeA)
+	if _, err := f.ServeDNS(context.TODO(), &test.ResponseWriter{}, okReq); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	nxReq := new(dns.Msg)
+	nxReq.SetQuestion("nope.", dns.TypeA)
+	if _, err := f.ServeDNS(context.TODO(), &test.ResponseWriter{}, nxReq); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := f.OnShutdown(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	records := recorder.all()
+	if len(records) != 2 {
+		t.Fatalf("expected 2 query log records, got %d", len(records))
+	}
+
+	if records[0].Qname != testQuery || records[0].Rcode != "NOERROR" || records[0].AnswerCount != 1 {
+		t.Errorf("unexpected record for the successful query: %+v", records[0])
+	}
+	if records[1].Qname != "nope." || records[1].Rcode != "NXDOMAIN" {
+		t.Errorf("unexpected record for the NXDOMAIN query: %+v", records[1])
+	}
+	for _, rec := range records {
+		if rec.Upstream != s.addr {
+			t.Errorf("expected upstream %s, got %s", s.addr, rec.Upstream)
+		}
+	}
+}
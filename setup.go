@@ -0,0 +1,290 @@
+// Copyright (c) 2020 Doc.ai and/or its affiliates.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fanout
+
+import (
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+)
+
+var errInvalidCA = errors.New("fanout: tls_ca contains no certificates")
+
+func init() {
+	caddy.RegisterPlugin("fanout", caddy.Plugin{
+		ServerType: "dns",
+		Action:     setup,
+	})
+}
+
+func setup(c *caddy.Controller) error {
+	f, err := parseFanout(c)
+	if err != nil {
+		return plugin.Error("fanout", err)
+	}
+
+	c.OnStartup(func() error {
+		return f.OnStartup()
+	})
+	c.OnShutdown(func() error {
+		return f.OnShutdown()
+	})
+
+	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
+		f.Next = next
+		return f
+	})
+
+	return nil
+}
+
+// OnStartup starts any background machinery the fanout plugin needs.
+func (f *Fanout) OnStartup() error {
+	if f.healthCheckInterval > 0 {
+		go f.healthCheckLoop()
+	}
+	f.startQueryLog()
+	return nil
+}
+
+// OnShutdown tears down any background machinery the fanout plugin started.
+func (f *Fanout) OnShutdown() error {
+	close(f.stopCh)
+	return f.stopQueryLog()
+}
+
+// parseFanout parses the fanout directive out of a Corefile.
+//
+//	fanout FROM TO... {
+//	    network tcp
+//	    policy first
+//	    tls_servername name
+//	    tls_ca /path/to/ca.pem
+//	    bootstrap 8.8.8.8:53 1.1.1.1:53
+//	    health_check 5s
+//	    health_check_domain .
+//	    max_fails 2
+//	    expire 10s
+//	    cache 1000 1h 30s
+//	    ecs_policy strip
+//	    cookies off
+//	    log stdout
+//	}
+//
+// TO entries may be plain addresses (dialed over network), or carry a
+// tls:// or https:// scheme to use DNS-over-TLS or DNS-over-HTTPS instead.
+func parseFanout(c *caddy.Controller) (*Fanout, error) {
+	f := New()
+
+	for c.Next() {
+		args := c.RemainingArgs()
+		if len(args) < 2 {
+			return nil, c.ArgErr()
+		}
+
+		f.from = plugin.Host(args[0]).Normalize()
+		rawHosts := args[1:]
+
+		for c.NextBlock() {
+			if err := parseBlock(c, f); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, host := range rawHosts {
+			cl, err := f.newClientFor(host)
+			if err != nil {
+				return nil, err
+			}
+			f.addClient(cl)
+		}
+	}
+
+	return f, nil
+}
+
+// newClientFor builds a Client for host, honoring a tls:// or https://
+// scheme prefix. A bare host is dialed over f.net (udp/tcp).
+func (f *Fanout) newClientFor(host string) (*Client, error) {
+	switch {
+	case strings.HasPrefix(host, "https://"):
+		return NewDoHClient(host, f.tlsConfig, f.bootstrap)
+	case strings.HasPrefix(host, "tls://"):
+		return NewTLSClient(strings.TrimPrefix(host, "tls://"), f.tlsConfig), nil
+	default:
+		return NewClient(host, f.net), nil
+	}
+}
+
+func parseBlock(c *caddy.Controller, f *Fanout) error {
+	switch strings.ToLower(c.Val()) {
+	case "network":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		f.net = strings.ToLower(c.Val())
+	case "worker_count":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		n, err := strconv.Atoi(c.Val())
+		if err != nil {
+			return c.Err(err.Error())
+		}
+		f.workerCount = n
+	case "tls_servername":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		f.tlsConfig.ServerName = c.Val()
+	case "tls_ca":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		pool, err := loadCertPool(c.Val())
+		if err != nil {
+			return c.Err(err.Error())
+		}
+		f.tlsConfig.RootCAs = pool
+	case "bootstrap":
+		args := c.RemainingArgs()
+		if len(args) == 0 {
+			return c.ArgErr()
+		}
+		f.bootstrap = args
+	case "policy":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		p, err := parsePolicy(strings.ToLower(c.Val()))
+		if err != nil {
+			return c.Err(err.Error())
+		}
+		f.policy = p
+	case "health_check":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		d, err := time.ParseDuration(c.Val())
+		if err != nil {
+			return c.Err(err.Error())
+		}
+		f.healthCheckInterval = d
+	case "health_check_domain":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		f.healthCheckDomain = c.Val()
+	case "max_fails":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		n, err := strconv.Atoi(c.Val())
+		if err != nil {
+			return c.Err(err.Error())
+		}
+		f.maxFails = int32(n)
+	case "expire":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		d, err := time.ParseDuration(c.Val())
+		if err != nil {
+			return c.Err(err.Error())
+		}
+		f.expire = d
+	case "cache":
+		args := c.RemainingArgs()
+		if len(args) == 0 {
+			return c.ArgErr()
+		}
+		size, err := strconv.Atoi(args[0])
+		if err != nil {
+			return c.Err(err.Error())
+		}
+		var successTTL, denialTTL time.Duration
+		if len(args) > 1 {
+			if successTTL, err = time.ParseDuration(args[1]); err != nil {
+				return c.Err(err.Error())
+			}
+		}
+		if len(args) > 2 {
+			if denialTTL, err = time.ParseDuration(args[2]); err != nil {
+				return c.Err(err.Error())
+			}
+		}
+		f.cache = newResponseCache(size, successTTL, denialTTL)
+	case "ecs_policy":
+		args := c.RemainingArgs()
+		if len(args) == 0 {
+			return c.ArgErr()
+		}
+		var cidr string
+		if len(args) > 1 {
+			cidr = args[1]
+		}
+		p, err := parseECSPolicy(strings.ToLower(args[0]), cidr)
+		if err != nil {
+			return c.Err(err.Error())
+		}
+		f.ecsPolicy = p
+	case "cookies":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		switch strings.ToLower(c.Val()) {
+		case "on":
+			f.cookiesEnabled = true
+		case "off":
+			f.cookiesEnabled = false
+		default:
+			return c.ArgErr()
+		}
+	case "log":
+		args := c.RemainingArgs()
+		if len(args) == 0 {
+			return c.ArgErr()
+		}
+		l, err := parseQueryLogger(args)
+		if err != nil {
+			return c.Err(err.Error())
+		}
+		f.queryLog = l
+	default:
+		return c.ArgErr()
+	}
+	return nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errInvalidCA
+	}
+	return pool, nil
+}